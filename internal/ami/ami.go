@@ -0,0 +1,91 @@
+// Package ami resolves the AMI ID to launch instances from, so that ID
+// doesn't have to be hardcoded (and go stale, or break outside the region
+// it was copied from) in the caller.
+package ami
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// DefaultSSMParameterName is the public SSM parameter AWS publishes the
+// latest Amazon Linux 2023 AMI ID under, for the region the client is
+// configured against.
+const DefaultSSMParameterName = "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64"
+
+// Resolver returns the AMI ID to launch instances from.
+type Resolver interface {
+	Resolve(ctx context.Context) (string, error)
+}
+
+// SSMResolver resolves the AMI ID from an SSM public parameter that AWS
+// keeps pointed at the latest AMI for a given OS/region, so callers never
+// need to track AMI IDs themselves.
+type SSMResolver struct {
+	client        *ssm.Client
+	parameterName string
+}
+
+// NewSSMResolver returns a Resolver that reads parameterName via
+// GetParameter on every call.
+func NewSSMResolver(client *ssm.Client, parameterName string) *SSMResolver {
+	return &SSMResolver{client: client, parameterName: parameterName}
+}
+
+func (r *SSMResolver) Resolve(ctx context.Context) (string, error) {
+	output, err := r.client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name: aws.String(r.parameterName),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error getting SSM parameter %s: %w", r.parameterName, err)
+	}
+
+	return *output.Parameter.Value, nil
+}
+
+// DescribeImagesResolver resolves the AMI ID by listing images owned by
+// owner whose name matches namePattern (an EC2 DescribeImages name
+// filter, e.g. "al2023-ami-*-x86_64") and picking the most recently
+// created one. It's an alternative to SSMResolver for AMIs that aren't
+// published as an SSM parameter.
+type DescribeImagesResolver struct {
+	client      *ec2.Client
+	owner       string
+	namePattern string
+}
+
+// NewDescribeImagesResolver returns a Resolver that lists images owned by
+// owner matching namePattern on every call.
+func NewDescribeImagesResolver(client *ec2.Client, owner string, namePattern string) *DescribeImagesResolver {
+	return &DescribeImagesResolver{client: client, owner: owner, namePattern: namePattern}
+}
+
+func (r *DescribeImagesResolver) Resolve(ctx context.Context) (string, error) {
+	output, err := r.client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners: []string{r.owner},
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("name"),
+				Values: []string{r.namePattern},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error describing images owned by %s matching %s: %w", r.owner, r.namePattern, err)
+	}
+	if len(output.Images) == 0 {
+		return "", fmt.Errorf("no images owned by %s match %s", r.owner, r.namePattern)
+	}
+
+	sort.Slice(output.Images, func(i, j int) bool {
+		return *output.Images[i].CreationDate > *output.Images[j].CreationDate
+	})
+
+	return *output.Images[0].ImageId, nil
+}
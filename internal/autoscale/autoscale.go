@@ -0,0 +1,372 @@
+// Package autoscale provisions the load-balanced, auto-scaling web tier:
+// an Application Load Balancer + Target Group in front of an EC2 Auto
+// Scaling Group, with CloudWatch-backed target-tracking scaling policies.
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/google/uuid"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/state"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/tags"
+)
+
+const (
+	listenerPort   = 8080
+	healthCheckURL = "/"
+
+	minSize         = 2
+	maxSize         = 4
+	desiredCapacity = 2
+
+	targetTrackingCPUValue          = 60.0
+	targetTrackingRequestCountValue = 1000.0
+
+	targetHealthPollInterval = 10 * time.Second
+	targetHealthTimeout      = 5 * time.Minute
+)
+
+// Clients bundles the AWS SDK clients this package needs. It mirrors the
+// single *ec2.Client parameter pattern used elsewhere in main, just scoped
+// to the services the load-balanced ASG touches.
+type Clients struct {
+	ELBV2 *elasticloadbalancingv2.Client
+	ASG   *autoscaling.Client
+}
+
+// Result holds the identifiers callers need after the web tier comes up.
+type Result struct {
+	LoadBalancerARN    string
+	LoadBalancerDNS    string
+	TargetGroupARN     string
+	AutoScalingGroupID string
+}
+
+// CreateAutoScalingGroup provisions an ALB + Target Group and an Auto
+// Scaling Group (backed by the given launch template) spread across
+// subnetIDs, registers the group with the target group, attaches
+// target-tracking scaling policies, and waits for the initial instances to
+// report healthy before returning.
+//
+// It supersedes the old CreateEC2Instances: instead of a fixed-size
+// RunInstances call, capacity is now managed by the ASG and scaling
+// policies. Each step is recorded in st, so a second call against the same
+// state resumes instead of re-creating resources that already exist: this
+// is what makes retries safe.
+func CreateAutoScalingGroup(ctx context.Context, logger *log.Logger, clients *Clients, tagSpec *tags.Spec, st *state.State, vpcID string, subnetIDs []string, securityGroupID string, launchTemplateID string) (*Result, error) {
+	if len(subnetIDs) < 2 {
+		return nil, fmt.Errorf("autoscale: at least two subnets in distinct AZs are required for an internet-facing load balancer, got %d", len(subnetIDs))
+	}
+
+	targetGroupARN, err := createTargetGroup(ctx, logger, clients.ELBV2, vpcID, tagSpec, st)
+	if err != nil {
+		return nil, err
+	}
+
+	lbARN, lbDNS, err := createLoadBalancer(ctx, logger, clients.ELBV2, subnetIDs, securityGroupID, tagSpec, st)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := createListener(ctx, logger, clients.ELBV2, lbARN, targetGroupARN); err != nil {
+		return nil, err
+	}
+
+	asgName, err := createGroup(ctx, logger, clients.ASG, launchTemplateID, subnetIDs, targetGroupARN, tagSpec, st)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := createScalingPolicies(ctx, logger, clients.ASG, asgName, lbARN, targetGroupARN); err != nil {
+		return nil, err
+	}
+
+	if err := waitForHealthyTargets(ctx, logger, clients.ELBV2, targetGroupARN); err != nil {
+		return nil, fmt.Errorf("error waiting for targets to become healthy: %w", err)
+	}
+	logger.Println("All targets are healthy")
+
+	return &Result{
+		LoadBalancerARN:    lbARN,
+		LoadBalancerDNS:    lbDNS,
+		TargetGroupARN:     targetGroupARN,
+		AutoScalingGroupID: asgName,
+	}, nil
+}
+
+func createTargetGroup(ctx context.Context, logger *log.Logger, client *elasticloadbalancingv2.Client, vpcID string, tagSpec *tags.Spec, st *state.State) (string, error) {
+	return state.Ensure(ctx, logger, st, "target_group", targetGroupExists(client), func(ctx context.Context, record func(id string) error) (string, error) {
+		name := "webservice-tg-" + uuid.NewString()[:8]
+		output, err := client.CreateTargetGroup(ctx, &elasticloadbalancingv2.CreateTargetGroupInput{
+			Name:            aws.String(name),
+			Port:            aws.Int32(listenerPort),
+			Protocol:        elbtypes.ProtocolEnumHttp,
+			VpcId:           aws.String(vpcID),
+			TargetType:      elbtypes.TargetTypeEnumInstance,
+			HealthCheckPath: aws.String(healthCheckURL),
+			Tags:            tagSpec.ELBV2(name),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating target group: %w", err)
+		}
+		targetGroupARN := *output.TargetGroups[0].TargetGroupArn
+		logger.Printf("Target group created with ARN: %s", targetGroupARN)
+
+		return targetGroupARN, nil
+	})
+}
+
+func targetGroupExists(client *elasticloadbalancingv2.Client) func(ctx context.Context, arn string) (bool, error) {
+	return func(ctx context.Context, arn string) (bool, error) {
+		_, err := client.DescribeTargetGroups(ctx, &elasticloadbalancingv2.DescribeTargetGroupsInput{TargetGroupArns: []string{arn}})
+		return state.DescribeExists(err, "TargetGroupNotFound")
+	}
+}
+
+func createLoadBalancer(ctx context.Context, logger *log.Logger, client *elasticloadbalancingv2.Client, subnetIDs []string, securityGroupID string, tagSpec *tags.Spec, st *state.State) (string, string, error) {
+	lbARN, err := state.Ensure(ctx, logger, st, "load_balancer", loadBalancerExists(client), func(ctx context.Context, record func(id string) error) (string, error) {
+		name := "webservice-alb-" + uuid.NewString()[:8]
+		output, err := client.CreateLoadBalancer(ctx, &elasticloadbalancingv2.CreateLoadBalancerInput{
+			Name:           aws.String(name),
+			Subnets:        subnetIDs,
+			SecurityGroups: []string{securityGroupID},
+			Type:           elbtypes.LoadBalancerTypeEnumApplication,
+			Scheme:         elbtypes.LoadBalancerSchemeEnumInternetFacing,
+			Tags:           tagSpec.ELBV2(name),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating load balancer: %w", err)
+		}
+		lb := output.LoadBalancers[0]
+		logger.Printf("Load balancer created with ARN: %s, DNS name: %s", *lb.LoadBalancerArn, *lb.DNSName)
+
+		return *lb.LoadBalancerArn, nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	waiter := elasticloadbalancingv2.NewLoadBalancerAvailableWaiter(client)
+	logger.Println("Waiting for load balancer to become available...")
+	describeOutput, err := describeLoadBalancer(ctx, client, lbARN, waiter)
+	if err != nil {
+		return "", "", err
+	}
+
+	return lbARN, *describeOutput.DNSName, nil
+}
+
+func describeLoadBalancer(ctx context.Context, client *elasticloadbalancingv2.Client, lbARN string, waiter *elasticloadbalancingv2.LoadBalancerAvailableWaiter) (*elbtypes.LoadBalancer, error) {
+	if err := waiter.Wait(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []string{lbARN},
+	}, 5*time.Minute); err != nil {
+		return nil, fmt.Errorf("error waiting for load balancer to become available: %w", err)
+	}
+
+	output, err := client.DescribeLoadBalancers(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []string{lbARN},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing load balancer %s: %w", lbARN, err)
+	}
+
+	return &output.LoadBalancers[0], nil
+}
+
+func loadBalancerExists(client *elasticloadbalancingv2.Client) func(ctx context.Context, arn string) (bool, error) {
+	return func(ctx context.Context, arn string) (bool, error) {
+		_, err := client.DescribeLoadBalancers(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{LoadBalancerArns: []string{arn}})
+		return state.DescribeExists(err, "LoadBalancerNotFound")
+	}
+}
+
+func createListener(ctx context.Context, logger *log.Logger, client *elasticloadbalancingv2.Client, loadBalancerARN string, targetGroupARN string) error {
+	describeOutput, err := client.DescribeListeners(ctx, &elasticloadbalancingv2.DescribeListenersInput{
+		LoadBalancerArn: aws.String(loadBalancerARN),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing listeners for load balancer %s: %w", loadBalancerARN, err)
+	}
+	for _, listener := range describeOutput.Listeners {
+		if listener.Port != nil && *listener.Port == listenerPort {
+			logger.Printf("Listener on port %d already exists for load balancer %s, skipping", listenerPort, loadBalancerARN)
+			return nil
+		}
+	}
+
+	if _, err := client.CreateListener(ctx, &elasticloadbalancingv2.CreateListenerInput{
+		LoadBalancerArn: aws.String(loadBalancerARN),
+		Port:            aws.Int32(listenerPort),
+		Protocol:        elbtypes.ProtocolEnumHttp,
+		DefaultActions: []elbtypes.Action{
+			{
+				Type:           elbtypes.ActionTypeEnumForward,
+				TargetGroupArn: aws.String(targetGroupARN),
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("error creating listener on port %d: %w", listenerPort, err)
+	}
+	logger.Printf("Listener created on port %d, forwarding to target group %s", listenerPort, targetGroupARN)
+
+	return nil
+}
+
+func createGroup(ctx context.Context, logger *log.Logger, client *autoscaling.Client, launchTemplateID string, subnetIDs []string, targetGroupARN string, tagSpec *tags.Spec, st *state.State) (string, error) {
+	return state.Ensure(ctx, logger, st, "auto_scaling_group", autoScalingGroupExists(client), func(ctx context.Context, record func(id string) error) (string, error) {
+		asgName := "webservice-asg-" + uuid.NewString()
+
+		_, err := client.CreateAutoScalingGroup(ctx, &autoscaling.CreateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(asgName),
+			LaunchTemplate: &asgtypes.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String(launchTemplateID),
+				Version:          aws.String("$Latest"),
+			},
+			MinSize:                aws.Int32(minSize),
+			MaxSize:                aws.Int32(maxSize),
+			DesiredCapacity:        aws.Int32(desiredCapacity),
+			VPCZoneIdentifier:      aws.String(joinSubnetIDs(subnetIDs)),
+			TargetGroupARNs:        []string{targetGroupARN},
+			HealthCheckType:        aws.String("ELB"),
+			HealthCheckGracePeriod: aws.Int32(60),
+			Tags:                   tagSpec.AutoScaling(asgName),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating auto scaling group: %w", err)
+		}
+		logger.Printf("Auto Scaling Group created with name: %s, spanning subnets: %v", asgName, subnetIDs)
+
+		return asgName, nil
+	})
+}
+
+func autoScalingGroupExists(client *autoscaling.Client) func(ctx context.Context, name string) (bool, error) {
+	return func(ctx context.Context, name string) (bool, error) {
+		output, err := client.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []string{name},
+		})
+		if err != nil {
+			return false, err
+		}
+		return len(output.AutoScalingGroups) > 0, nil
+	}
+}
+
+func createScalingPolicies(ctx context.Context, logger *log.Logger, client *autoscaling.Client, asgName string, lbARN string, targetGroupARN string) error {
+	label, err := resourceLabel(lbARN, targetGroupARN)
+	if err != nil {
+		return err
+	}
+
+	policies := []struct {
+		name        string
+		metricSpec  *asgtypes.PredefinedMetricSpecification
+		targetValue float64
+	}{
+		{
+			"cpu-target-tracking",
+			&asgtypes.PredefinedMetricSpecification{PredefinedMetricType: asgtypes.MetricTypeASGAverageCPUUtilization},
+			targetTrackingCPUValue,
+		},
+		{
+			"request-count-target-tracking",
+			&asgtypes.PredefinedMetricSpecification{
+				PredefinedMetricType: asgtypes.MetricTypeALBRequestCountPerTarget,
+				ResourceLabel:        aws.String(label),
+			},
+			targetTrackingRequestCountValue,
+		},
+	}
+
+	for _, policy := range policies {
+		_, err := client.PutScalingPolicy(ctx, &autoscaling.PutScalingPolicyInput{
+			AutoScalingGroupName: aws.String(asgName),
+			PolicyName:           aws.String(asgName + "-" + policy.name),
+			PolicyType:           aws.String("TargetTrackingScaling"),
+			TargetTrackingConfiguration: &asgtypes.TargetTrackingConfiguration{
+				PredefinedMetricSpecification: policy.metricSpec,
+				TargetValue:                   aws.Float64(policy.targetValue),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error creating %s scaling policy: %w", policy.name, err)
+		}
+		logger.Printf("Created target-tracking scaling policy %q (target: %.1f)", policy.name, policy.targetValue)
+	}
+
+	return nil
+}
+
+// resourceLabel builds the PredefinedMetricSpecification.ResourceLabel
+// ALBRequestCountPerTarget requires: "app/<lb-name>/<lb-id>/targetgroup/<tg-name>/<tg-id>",
+// derived from the load balancer and target group ARN suffixes.
+func resourceLabel(lbARN string, targetGroupARN string) (string, error) {
+	_, lbSuffix, ok := strings.Cut(lbARN, "loadbalancer/")
+	if !ok {
+		return "", fmt.Errorf("load balancer ARN %s has unexpected format", lbARN)
+	}
+
+	_, tgSuffix, ok := strings.Cut(targetGroupARN, "targetgroup/")
+	if !ok {
+		return "", fmt.Errorf("target group ARN %s has unexpected format", targetGroupARN)
+	}
+
+	return fmt.Sprintf("%s/targetgroup/%s", lbSuffix, tgSuffix), nil
+}
+
+func waitForHealthyTargets(ctx context.Context, logger *log.Logger, client *elasticloadbalancingv2.Client, targetGroupARN string) error {
+	deadline := time.Now().Add(targetHealthTimeout)
+
+	for {
+		output, err := client.DescribeTargetHealth(ctx, &elasticloadbalancingv2.DescribeTargetHealthInput{
+			TargetGroupArn: aws.String(targetGroupARN),
+		})
+		if err != nil {
+			return fmt.Errorf("error describing target health: %w", err)
+		}
+
+		if len(output.TargetHealthDescriptions) > 0 && allHealthy(output.TargetHealthDescriptions) {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for targets to become healthy", targetHealthTimeout)
+		}
+
+		logger.Println("Targets not yet healthy, retrying...")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(targetHealthPollInterval):
+		}
+	}
+}
+
+func allHealthy(descriptions []elbtypes.TargetHealthDescription) bool {
+	for _, description := range descriptions {
+		if description.TargetHealth.State != elbtypes.TargetHealthStateEnumHealthy {
+			return false
+		}
+	}
+	return true
+}
+
+func joinSubnetIDs(subnetIDs []string) string {
+	joined := ""
+	for i, id := range subnetIDs {
+		if i > 0 {
+			joined += ","
+		}
+		joined += id
+	}
+	return joined
+}
@@ -0,0 +1,334 @@
+// Package network provisions the VPC-level plumbing the web tier runs on:
+// a VPC, public subnets spread across distinct Availability Zones, an
+// Internet Gateway, and the route table wiring needed for those subnets to
+// reach the internet.
+package network
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/shared"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/state"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/tags"
+)
+
+const (
+	vpcCIDR             = "10.0.0.0/16"
+	publicSubnetCIDRFmt = "10.0.%d.0/24"
+
+	// publicSubnetCount is the number of public subnets created, one per
+	// AZ, so the Auto Scaling Group can spread instances for availability.
+	publicSubnetCount = 2
+)
+
+// Network holds the identifiers of the VPC-level resources CreateNetwork
+// provisions, so downstream packages (security groups, autoscale) can
+// reference them without recreating anything.
+type Network struct {
+	VpcID              string
+	PublicSubnetIDs    []string
+	InternetGatewayID  string
+	PublicRouteTableID string
+}
+
+// CreateNetwork provisions a VPC with DNS hostnames enabled, publicSubnetCount
+// public subnets spread across distinct AZs in the current region, an
+// Internet Gateway attached to the VPC, and a public route table (with a
+// 0.0.0.0/0 route to the IGW) associated with every public subnet. Every
+// resource is stamped with tagSpec. Each step is recorded in st, so a
+// second call against the same state resumes instead of re-creating
+// resources that already exist.
+//
+// If sharedConfig has an existing VPC and/or subnets configured, those are
+// validated and reused instead of created, and are never mutated — this is
+// the bring-your-own-VPC path for running inside a pre-existing network. In
+// that case the Internet Gateway and route table are skipped too: a shared
+// VPC already has its own IGW (a VPC can only have one attached, so
+// creating another would fail), and a shared subnet already has its own
+// routing that this tool must not override.
+func CreateNetwork(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, tagSpec *tags.Spec, st *state.State, sharedConfig *shared.Config) (*Network, error) {
+	vpcID, err := createVPC(ctx, logger, ec2Client, tagSpec, st, sharedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var subnetIDs []string
+	if sharedConfig.Subnets() {
+		subnetIDs, err = useExistingSubnets(ctx, logger, ec2Client, vpcID, sharedConfig.SubnetIDs)
+	} else {
+		var azs []string
+		azs, err = availabilityZones(ctx, ec2Client, publicSubnetCount)
+		if err == nil {
+			subnetIDs, err = createPublicSubnets(ctx, logger, ec2Client, vpcID, azs, tagSpec, st)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sharedConfig.VPC() || sharedConfig.Subnets() {
+		logger.Println("Shared VPC or subnets in use, skipping Internet Gateway and route table provisioning")
+		return &Network{VpcID: vpcID, PublicSubnetIDs: subnetIDs}, nil
+	}
+
+	igwID, err := createInternetGateway(ctx, logger, ec2Client, vpcID, tagSpec, st)
+	if err != nil {
+		return nil, err
+	}
+
+	routeTableID, err := createPublicRouteTable(ctx, logger, ec2Client, vpcID, igwID, subnetIDs, tagSpec, st)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Network{
+		VpcID:              vpcID,
+		PublicSubnetIDs:    subnetIDs,
+		InternetGatewayID:  igwID,
+		PublicRouteTableID: routeTableID,
+	}, nil
+}
+
+func createVPC(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, tagSpec *tags.Spec, st *state.State, sharedConfig *shared.Config) (string, error) {
+	if sharedConfig.VPC() {
+		return useExistingVPC(ctx, logger, ec2Client, sharedConfig.VPCID)
+	}
+
+	return state.Ensure(ctx, logger, st, "vpc", vpcExists(ec2Client), func(ctx context.Context, record func(id string) error) (string, error) {
+		result, err := ec2Client.CreateVpc(ctx, &ec2.CreateVpcInput{
+			CidrBlock:         aws.String(vpcCIDR),
+			TagSpecifications: tagSpec.EC2(types.ResourceTypeVpc, "webservice-vpc"),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating VPC: %w", err)
+		}
+		vpcID := *result.Vpc.VpcId
+		logger.Printf("VPC created with ID: %s", vpcID)
+		if err := record(vpcID); err != nil {
+			return "", err
+		}
+
+		modifyVPC := &ec2.ModifyVpcAttributeInput{
+			VpcId: result.Vpc.VpcId,
+			EnableDnsHostnames: &types.AttributeBooleanValue{
+				Value: aws.Bool(true),
+			},
+		}
+		if _, err = ec2Client.ModifyVpcAttribute(ctx, modifyVPC); err != nil {
+			return "", fmt.Errorf("error enabling DNS hostnames: %w", err)
+		}
+		logger.Printf("DNS hostnames enabled for VPC with ID: %s", vpcID)
+
+		return vpcID, nil
+	})
+}
+
+func vpcExists(ec2Client *ec2.Client) func(ctx context.Context, id string) (bool, error) {
+	return func(ctx context.Context, id string) (bool, error) {
+		_, err := ec2Client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{VpcIds: []string{id}})
+		return state.DescribeExists(err, "InvalidVpcID.NotFound")
+	}
+}
+
+// useExistingVPC validates that vpcID exists and has DNS hostnames
+// enabled (required for the ALB's DNS name to resolve inside the VPC),
+// then returns it unchanged — it is never created, tagged, or modified.
+func useExistingVPC(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string) (string, error) {
+	if _, err := ec2Client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{VpcIds: []string{vpcID}}); err != nil {
+		return "", fmt.Errorf("error describing existing VPC %s: %w", vpcID, err)
+	}
+
+	attribute, err := ec2Client.DescribeVpcAttribute(ctx, &ec2.DescribeVpcAttributeInput{
+		VpcId:     aws.String(vpcID),
+		Attribute: types.VpcAttributeNameEnableDnsHostnames,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error describing DNS hostnames attribute of existing VPC %s: %w", vpcID, err)
+	}
+	if attribute.EnableDnsHostnames == nil || attribute.EnableDnsHostnames.Value == nil || !*attribute.EnableDnsHostnames.Value {
+		return "", fmt.Errorf("existing VPC %s must have DNS hostnames enabled (EnableDnsHostnames=true)", vpcID)
+	}
+
+	logger.Printf("Reusing existing VPC %s", vpcID)
+
+	return vpcID, nil
+}
+
+// availabilityZones returns count available AZ names for the region the
+// client is configured against, or an error if the region has fewer.
+func availabilityZones(ctx context.Context, ec2Client *ec2.Client, count int) ([]string, error) {
+	output, err := ec2Client.DescribeAvailabilityZones(ctx, &ec2.DescribeAvailabilityZonesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("state"),
+				Values: []string{"available"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing availability zones: %w", err)
+	}
+	if len(output.AvailabilityZones) < count {
+		return nil, fmt.Errorf("region has %d available AZs, need at least %d", len(output.AvailabilityZones), count)
+	}
+
+	azs := make([]string, count)
+	for i := 0; i < count; i++ {
+		azs[i] = *output.AvailabilityZones[i].ZoneName
+	}
+
+	return azs, nil
+}
+
+func createPublicSubnets(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string, azs []string, tagSpec *tags.Spec, st *state.State) ([]string, error) {
+	subnetIDs := make([]string, 0, len(azs))
+
+	for i, az := range azs {
+		key := fmt.Sprintf("public_subnet_%d", i)
+		name := fmt.Sprintf("webservice-public-subnet-%d", i+1)
+
+		subnetID, err := state.Ensure(ctx, logger, st, key, subnetExists(ec2Client), func(ctx context.Context, record func(id string) error) (string, error) {
+			result, err := ec2Client.CreateSubnet(ctx, &ec2.CreateSubnetInput{
+				VpcId:             aws.String(vpcID),
+				CidrBlock:         aws.String(fmt.Sprintf(publicSubnetCIDRFmt, i+1)),
+				AvailabilityZone:  aws.String(az),
+				TagSpecifications: tagSpec.EC2(types.ResourceTypeSubnet, name),
+			})
+			if err != nil {
+				return "", fmt.Errorf("error creating subnet in %s: %w", az, err)
+			}
+			subnetID := *result.Subnet.SubnetId
+			logger.Printf("Public subnet created with ID: %s in AZ: %s", subnetID, az)
+			if err := record(subnetID); err != nil {
+				return "", err
+			}
+
+			if _, err := ec2Client.ModifySubnetAttribute(ctx, &ec2.ModifySubnetAttributeInput{
+				SubnetId:            aws.String(subnetID),
+				MapPublicIpOnLaunch: &types.AttributeBooleanValue{Value: aws.Bool(true)},
+			}); err != nil {
+				return "", fmt.Errorf("error enabling auto-assign public IP for subnet %s: %w", subnetID, err)
+			}
+			logger.Printf("Auto-assign public IP enabled for subnet %s", subnetID)
+
+			return subnetID, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		subnetIDs = append(subnetIDs, subnetID)
+	}
+
+	return subnetIDs, nil
+}
+
+func subnetExists(ec2Client *ec2.Client) func(ctx context.Context, id string) (bool, error) {
+	return func(ctx context.Context, id string) (bool, error) {
+		_, err := ec2Client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{SubnetIds: []string{id}})
+		return state.DescribeExists(err, "InvalidSubnetID.NotFound")
+	}
+}
+
+// useExistingSubnets validates that every ID in subnetIDs exists and
+// belongs to vpcID, then returns subnetIDs unchanged — they are never
+// created, tagged, or modified.
+func useExistingSubnets(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string, subnetIDs []string) ([]string, error) {
+	output, err := ec2Client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{SubnetIds: subnetIDs})
+	if err != nil {
+		return nil, fmt.Errorf("error describing existing subnets %v: %w", subnetIDs, err)
+	}
+
+	for _, subnet := range output.Subnets {
+		if subnet.VpcId == nil || *subnet.VpcId != vpcID {
+			return nil, fmt.Errorf("existing subnet %s does not belong to VPC %s", *subnet.SubnetId, vpcID)
+		}
+	}
+
+	logger.Printf("Reusing existing subnets %v", subnetIDs)
+
+	return subnetIDs, nil
+}
+
+func createInternetGateway(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string, tagSpec *tags.Spec, st *state.State) (string, error) {
+	return state.Ensure(ctx, logger, st, "internet_gateway", internetGatewayExists(ec2Client), func(ctx context.Context, record func(id string) error) (string, error) {
+		result, err := ec2Client.CreateInternetGateway(ctx, &ec2.CreateInternetGatewayInput{
+			TagSpecifications: tagSpec.EC2(types.ResourceTypeInternetGateway, "webservice-igw"),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating internet gateway: %w", err)
+		}
+		igwID := *result.InternetGateway.InternetGatewayId
+		logger.Printf("Internet gateway created with ID: %s", igwID)
+		if err := record(igwID); err != nil {
+			return "", err
+		}
+
+		if _, err := ec2Client.AttachInternetGateway(ctx, &ec2.AttachInternetGatewayInput{
+			InternetGatewayId: aws.String(igwID),
+			VpcId:             aws.String(vpcID),
+		}); err != nil {
+			return "", fmt.Errorf("error attaching internet gateway %s to VPC %s: %w", igwID, vpcID, err)
+		}
+		logger.Printf("Internet gateway %s attached to VPC %s", igwID, vpcID)
+
+		return igwID, nil
+	})
+}
+
+func internetGatewayExists(ec2Client *ec2.Client) func(ctx context.Context, id string) (bool, error) {
+	return func(ctx context.Context, id string) (bool, error) {
+		_, err := ec2Client.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{InternetGatewayIds: []string{id}})
+		return state.DescribeExists(err, "InvalidInternetGatewayID.NotFound")
+	}
+}
+
+func createPublicRouteTable(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string, igwID string, subnetIDs []string, tagSpec *tags.Spec, st *state.State) (string, error) {
+	return state.Ensure(ctx, logger, st, "route_table", routeTableExists(ec2Client), func(ctx context.Context, record func(id string) error) (string, error) {
+		result, err := ec2Client.CreateRouteTable(ctx, &ec2.CreateRouteTableInput{
+			VpcId:             aws.String(vpcID),
+			TagSpecifications: tagSpec.EC2(types.ResourceTypeRouteTable, "webservice-public-rt"),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating route table: %w", err)
+		}
+		routeTableID := *result.RouteTable.RouteTableId
+		logger.Printf("Route table created with ID: %s", routeTableID)
+		if err := record(routeTableID); err != nil {
+			return "", err
+		}
+
+		if _, err := ec2Client.CreateRoute(ctx, &ec2.CreateRouteInput{
+			RouteTableId:         aws.String(routeTableID),
+			DestinationCidrBlock: aws.String("0.0.0.0/0"),
+			GatewayId:            aws.String(igwID),
+		}); err != nil {
+			return "", fmt.Errorf("error adding default route to internet gateway %s: %w", igwID, err)
+		}
+		logger.Printf("Default route to internet gateway %s added to route table %s", igwID, routeTableID)
+
+		for _, subnetID := range subnetIDs {
+			if _, err := ec2Client.AssociateRouteTable(ctx, &ec2.AssociateRouteTableInput{
+				RouteTableId: aws.String(routeTableID),
+				SubnetId:     aws.String(subnetID),
+			}); err != nil {
+				return "", fmt.Errorf("error associating route table %s with subnet %s: %w", routeTableID, subnetID, err)
+			}
+			logger.Printf("Route table %s associated with subnet %s", routeTableID, subnetID)
+		}
+
+		return routeTableID, nil
+	})
+}
+
+func routeTableExists(ec2Client *ec2.Client) func(ctx context.Context, id string) (bool, error) {
+	return func(ctx context.Context, id string) (bool, error) {
+		_, err := ec2Client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{RouteTableIds: []string{id}})
+		return state.DescribeExists(err, "InvalidRouteTableID.NotFound")
+	}
+}
@@ -0,0 +1,74 @@
+// Package shared lets this tool run inside a pre-existing, corporate-owned
+// VPC instead of provisioning its own networking: callers point it at an
+// existing VPC, subnets, and security group via environment variables, and
+// the provisioning packages validate and reuse those IDs instead of
+// creating (or ever mutating) them.
+package shared
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	// ExistingVPCIDEnvVar, if set, skips VPC creation and reuses this VPC.
+	ExistingVPCIDEnvVar = "EXISTING_VPC_ID"
+
+	// ExistingSubnetIDsEnvVar, if set, skips subnet creation and reuses
+	// these comma-separated subnet IDs.
+	ExistingSubnetIDsEnvVar = "EXISTING_SUBNET_IDS"
+
+	// ExistingSecurityGroupIDEnvVar, if set, skips security group creation
+	// and reuses this security group.
+	ExistingSecurityGroupIDEnvVar = "EXISTING_SG_ID"
+)
+
+// Config describes the externally-owned resources to reuse, read from the
+// EXISTING_* environment variables. A zero-value field means that
+// resource should still be created by this tool.
+type Config struct {
+	VPCID           string
+	SubnetIDs       []string
+	SecurityGroupID string
+}
+
+// Load reads Config from the environment.
+func Load() *Config {
+	return &Config{
+		VPCID:           os.Getenv(ExistingVPCIDEnvVar),
+		SubnetIDs:       parseCSV(os.Getenv(ExistingSubnetIDsEnvVar)),
+		SecurityGroupID: os.Getenv(ExistingSecurityGroupIDEnvVar),
+	}
+}
+
+// VPC reports whether an existing VPC should be reused.
+func (c *Config) VPC() bool {
+	return c.VPCID != ""
+}
+
+// Subnets reports whether existing subnets should be reused.
+func (c *Config) Subnets() bool {
+	return len(c.SubnetIDs) > 0
+}
+
+// SecurityGroup reports whether an existing security group should be
+// reused.
+func (c *Config) SecurityGroup() bool {
+	return c.SecurityGroupID != ""
+}
+
+func parseCSV(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+
+	parts := strings.Split(raw, ",")
+	ids := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if id := strings.TrimSpace(part); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
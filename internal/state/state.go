@@ -0,0 +1,144 @@
+// Package state persists the IDs of resources a deployment has already
+// created to a JSON file on disk, so a second run of main against the same
+// file resumes instead of re-provisioning (and potentially leaking
+// duplicate resources) after a partial failure.
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	smithy "github.com/aws/smithy-go"
+)
+
+// State tracks one deployment's resource IDs, keyed by a short name per
+// resource (e.g. "vpc", "public_subnet_0", "auto_scaling_group").
+type State struct {
+	DeploymentID string            `json:"deployment_id"`
+	Resources    map[string]string `json:"resources"`
+
+	path string
+}
+
+// New starts empty state for a fresh deployment. Call Record as resources
+// are created; the file at path is written on the first Record call.
+func New(path string, deploymentID string) *State {
+	return &State{
+		DeploymentID: deploymentID,
+		Resources:    make(map[string]string),
+		path:         path,
+	}
+}
+
+// Load reads state previously written to path. It returns the same error
+// os.ReadFile would (including a wrapped os.ErrNotExist when path doesn't
+// exist yet), so callers can fall back to New with errors.Is.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+	if s.Resources == nil {
+		s.Resources = make(map[string]string)
+	}
+	s.path = path
+
+	return &s, nil
+}
+
+// Get returns the resource ID recorded under key, if any.
+func (s *State) Get(key string) (string, bool) {
+	id, ok := s.Resources[key]
+	return id, ok
+}
+
+// Record stores id under key and persists the state file immediately, so a
+// crash right after this call still leaves the resource discoverable on
+// the next run.
+func (s *State) Record(key string, id string) error {
+	s.Resources[key] = id
+	return s.save()
+}
+
+func (s *State) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling state: %w", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing state file %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("error committing state file %s: %w", s.path, err)
+	}
+
+	return nil
+}
+
+// Ensure makes a provisioning step idempotent: if key is already recorded
+// and describe confirms the resource still exists, creation is skipped and
+// the recorded ID is returned; otherwise create runs and its result is
+// recorded under key.
+//
+// create is passed a record callback. If it makes any AWS calls after the
+// primary resource exists (tagging aside, e.g. attaching, authorizing,
+// associating), it must call record with that resource's ID as soon as the
+// resource is created and before making those further calls: that way a
+// failure partway through still leaves the primary resource's ID
+// persisted, so a retry finds it instead of creating a duplicate. create
+// can also ignore record and just return the ID when it makes no further
+// calls; Ensure records it either way.
+func Ensure(ctx context.Context, logger *log.Logger, s *State, key string, describe func(ctx context.Context, id string) (bool, error), create func(ctx context.Context, record func(id string) error) (string, error)) (string, error) {
+	if id, ok := s.Get(key); ok {
+		exists, err := describe(ctx, id)
+		if err != nil {
+			return "", fmt.Errorf("error checking existing %s %s: %w", key, id, err)
+		}
+		if exists {
+			logger.Printf("%s already provisioned as %s, skipping", key, id)
+			return id, nil
+		}
+		logger.Printf("%s was recorded as %s but no longer exists, recreating", key, id)
+	}
+
+	id, err := create(ctx, func(id string) error { return s.Record(key, id) })
+	if err != nil {
+		return "", err
+	}
+	if recorded, ok := s.Get(key); !ok || recorded != id {
+		if err := s.Record(key, id); err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// DescribeExists turns a Describe* error into an (exists, error) pair: a
+// not-found API error (identified by notFoundCode) means the resource is
+// gone (exists=false, no error), any other error is propagated as-is. It's
+// the shared building block behind the describe callbacks passed to
+// Ensure.
+func DescribeExists(err error, notFoundCode string) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == notFoundCode {
+		return false, nil
+	}
+
+	return false, err
+}
@@ -0,0 +1,137 @@
+// Package tags centralizes the tagging policy applied to every resource
+// this tool creates, following the same pattern used by cloud provisioners
+// like kops and cloud-prepare: a shared set of tags (Name, a deployment ID,
+// ManagedBy) plus optional user-supplied key=value pairs, so resources can
+// be discovered and cleaned up by tag later.
+package tags
+
+import (
+	"os"
+	"strings"
+
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// ManagedBy is stamped on every resource this tool creates, so they can
+	// be told apart from resources created by hand or by other tools.
+	ManagedBy = "aws-autoscaling-pzc"
+
+	// DeploymentIDKey is the tag key used to group every resource created by
+	// a single run, for discovery and teardown.
+	DeploymentIDKey = "DeploymentId"
+
+	managedByKey = "ManagedBy"
+	nameKey      = "Name"
+
+	// extraTagsEnvVar holds comma-separated key=value pairs the caller wants
+	// applied on top of the base tag set, e.g. "Environment=staging,Team=web".
+	extraTagsEnvVar = "EXTRA_TAGS"
+)
+
+// Spec is the shared set of tags applied to a deployment's resources.
+type Spec struct {
+	DeploymentID string
+	tags         map[string]string
+}
+
+// NewSpec builds a Spec for deploymentID, seeded with ManagedBy and the
+// deployment ID, plus any key=value pairs found in the EXTRA_TAGS
+// environment variable.
+func NewSpec(deploymentID string) *Spec {
+	spec := &Spec{
+		DeploymentID: deploymentID,
+		tags: map[string]string{
+			managedByKey:    ManagedBy,
+			DeploymentIDKey: deploymentID,
+		},
+	}
+
+	for key, value := range parseExtraTags(os.Getenv(extraTagsEnvVar)) {
+		spec.tags[key] = value
+	}
+
+	return spec
+}
+
+func parseExtraTags(raw string) map[string]string {
+	extra := make(map[string]string)
+	if raw == "" {
+		return extra
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		extra[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return extra
+}
+
+// merged returns the base tag set plus a Name tag for name.
+func (s *Spec) merged(name string) map[string]string {
+	merged := make(map[string]string, len(s.tags)+1)
+	for key, value := range s.tags {
+		merged[key] = value
+	}
+	merged[nameKey] = name
+
+	return merged
+}
+
+// EC2 builds the TagSpecifications for an EC2-family create call (VPC,
+// subnet, security group, launch template, instance, ...) named name.
+func (s *Spec) EC2(resourceType ec2types.ResourceType, name string) []ec2types.TagSpecification {
+	merged := s.merged(name)
+	ec2Tags := make([]ec2types.Tag, 0, len(merged))
+	for key, value := range merged {
+		ec2Tags = append(ec2Tags, ec2types.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(value),
+		})
+	}
+
+	return []ec2types.TagSpecification{
+		{
+			ResourceType: resourceType,
+			Tags:         ec2Tags,
+		},
+	}
+}
+
+// ELBV2 builds the flat Tags list CreateLoadBalancer/CreateTargetGroup
+// expect, named name.
+func (s *Spec) ELBV2(name string) []elbtypes.Tag {
+	merged := s.merged(name)
+	elbTags := make([]elbtypes.Tag, 0, len(merged))
+	for key, value := range merged {
+		elbTags = append(elbTags, elbtypes.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(value),
+		})
+	}
+
+	return elbTags
+}
+
+// AutoScaling builds the Tags list CreateAutoScalingGroup expects, named
+// name. Tags are propagated to instances launched by the group.
+func (s *Spec) AutoScaling(name string) []asgtypes.Tag {
+	merged := s.merged(name)
+	asgTags := make([]asgtypes.Tag, 0, len(merged))
+	for key, value := range merged {
+		asgTags = append(asgTags, asgtypes.Tag{
+			Key:               aws.String(key),
+			Value:             aws.String(value),
+			PropagateAtLaunch: aws.Bool(true),
+		})
+	}
+
+	return asgTags
+}
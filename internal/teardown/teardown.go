@@ -0,0 +1,514 @@
+// Package teardown reverses what network, autoscale, and main provisioned:
+// given a deployment ID, it discovers every resource stamped with that
+// DeploymentId tag and deletes it in dependency order, retrying deletes
+// that race a still-draining dependency.
+package teardown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	asgtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go/aws"
+	smithy "github.com/aws/smithy-go"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/tags"
+)
+
+const (
+	asgDeletePollInterval = 10 * time.Second
+	asgDeleteTimeout      = 5 * time.Minute
+
+	dependencyViolationRetries  = 12
+	dependencyViolationInterval = 10 * time.Second
+
+	// elbv2DescribeTagsBatchSize is the max ResourceArns DescribeTags
+	// accepts per call.
+	elbv2DescribeTagsBatchSize = 20
+)
+
+// Clients bundles the AWS SDK clients teardown needs, mirroring
+// autoscale.Clients plus the bare ec2.Client main already wires up.
+type Clients struct {
+	EC2   *ec2.Client
+	ELBV2 *elasticloadbalancingv2.Client
+	ASG   *autoscaling.Client
+}
+
+// Destroy deletes every resource tagged with deploymentID, in the reverse
+// order network and autoscale created them: Auto Scaling Group (which
+// terminates its own instances), load balancer, target group, any
+// remaining EC2 instances, launch template, security groups, Internet
+// Gateway, route tables, subnets, and finally the VPC.
+func Destroy(ctx context.Context, logger *log.Logger, clients *Clients, deploymentID string) error {
+	if err := destroyAutoScalingGroups(ctx, logger, clients.ASG, deploymentID); err != nil {
+		return err
+	}
+
+	if err := destroyLoadBalancers(ctx, logger, clients.ELBV2, deploymentID); err != nil {
+		return err
+	}
+
+	if err := destroyTargetGroups(ctx, logger, clients.ELBV2, deploymentID); err != nil {
+		return err
+	}
+
+	if err := destroyInstances(ctx, logger, clients.EC2, deploymentID); err != nil {
+		return err
+	}
+
+	if err := destroyLaunchTemplates(ctx, logger, clients.EC2, deploymentID); err != nil {
+		return err
+	}
+
+	if err := destroySecurityGroups(ctx, logger, clients.EC2, deploymentID); err != nil {
+		return err
+	}
+
+	if err := destroyInternetGateways(ctx, logger, clients.EC2, deploymentID); err != nil {
+		return err
+	}
+
+	if err := destroyRouteTables(ctx, logger, clients.EC2, deploymentID); err != nil {
+		return err
+	}
+
+	if err := destroySubnets(ctx, logger, clients.EC2, deploymentID); err != nil {
+		return err
+	}
+
+	if err := destroyVpcs(ctx, logger, clients.EC2, deploymentID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func deploymentTagFilter(deploymentID string) []types.Filter {
+	return []types.Filter{
+		{
+			Name:   aws.String("tag:" + tags.DeploymentIDKey),
+			Values: []string{deploymentID},
+		},
+	}
+}
+
+func destroyAutoScalingGroups(ctx context.Context, logger *log.Logger, client *autoscaling.Client, deploymentID string) error {
+	tagsOutput, err := client.DescribeTags(ctx, &autoscaling.DescribeTagsInput{
+		Filters: []asgtypes.Filter{
+			{Name: aws.String("key"), Values: []string{tags.DeploymentIDKey}},
+			{Name: aws.String("value"), Values: []string{deploymentID}},
+			{Name: aws.String("resource-type"), Values: []string{"auto-scaling-group"}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing auto scaling groups for deployment %s: %w", deploymentID, err)
+	}
+
+	for _, tagDescription := range tagsOutput.Tags {
+		asgName := *tagDescription.ResourceId
+
+		if _, err := client.DeleteAutoScalingGroup(ctx, &autoscaling.DeleteAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(asgName),
+			ForceDelete:          aws.Bool(true),
+		}); err != nil {
+			return fmt.Errorf("error deleting auto scaling group %s: %w", asgName, err)
+		}
+		logger.Printf("Delete requested for Auto Scaling Group %s, waiting for it to terminate...", asgName)
+
+		if err := waitForAutoScalingGroupGone(ctx, client, asgName); err != nil {
+			return err
+		}
+		logger.Printf("Auto Scaling Group %s deleted", asgName)
+	}
+
+	return nil
+}
+
+func waitForAutoScalingGroupGone(ctx context.Context, client *autoscaling.Client, asgName string) error {
+	deadline := time.Now().Add(asgDeleteTimeout)
+
+	for {
+		output, err := client.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []string{asgName},
+		})
+		if err != nil {
+			return fmt.Errorf("error describing auto scaling group %s: %w", asgName, err)
+		}
+		if len(output.AutoScalingGroups) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for auto scaling group %s to delete", asgDeleteTimeout, asgName)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(asgDeletePollInterval):
+		}
+	}
+}
+
+func destroyLoadBalancers(ctx context.Context, logger *log.Logger, client *elasticloadbalancingv2.Client, deploymentID string) error {
+	allARNs, err := listAllLoadBalancerARNs(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	arns, err := filterTaggedELBV2ARNs(ctx, client, deploymentID, allARNs)
+	if err != nil {
+		return err
+	}
+
+	for _, arn := range arns {
+		if _, err := client.DeleteLoadBalancer(ctx, &elasticloadbalancingv2.DeleteLoadBalancerInput{
+			LoadBalancerArn: aws.String(arn),
+		}); err != nil {
+			return fmt.Errorf("error deleting load balancer %s: %w", arn, err)
+		}
+		logger.Printf("Delete requested for load balancer %s, waiting for it to be removed...", arn)
+
+		waiter := elasticloadbalancingv2.NewLoadBalancersDeletedWaiter(client)
+		if err := waiter.Wait(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+			LoadBalancerArns: []string{arn},
+		}, asgDeleteTimeout); err != nil {
+			return fmt.Errorf("error waiting for load balancer %s to be removed: %w", arn, err)
+		}
+		logger.Printf("Load balancer %s deleted", arn)
+	}
+
+	return nil
+}
+
+func listAllLoadBalancerARNs(ctx context.Context, client *elasticloadbalancingv2.Client) ([]string, error) {
+	var arns []string
+	var marker *string
+
+	for {
+		output, err := client.DescribeLoadBalancers(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+			Marker: marker,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing load balancers: %w", err)
+		}
+		for _, lb := range output.LoadBalancers {
+			arns = append(arns, *lb.LoadBalancerArn)
+		}
+		if output.NextMarker == nil {
+			return arns, nil
+		}
+		marker = output.NextMarker
+	}
+}
+
+func destroyTargetGroups(ctx context.Context, logger *log.Logger, client *elasticloadbalancingv2.Client, deploymentID string) error {
+	allARNs, err := listAllTargetGroupARNs(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	arns, err := filterTaggedELBV2ARNs(ctx, client, deploymentID, allARNs)
+	if err != nil {
+		return err
+	}
+
+	for _, arn := range arns {
+		if _, err := client.DeleteTargetGroup(ctx, &elasticloadbalancingv2.DeleteTargetGroupInput{
+			TargetGroupArn: aws.String(arn),
+		}); err != nil {
+			return fmt.Errorf("error deleting target group %s: %w", arn, err)
+		}
+		logger.Printf("Target group %s deleted", arn)
+	}
+
+	return nil
+}
+
+func listAllTargetGroupARNs(ctx context.Context, client *elasticloadbalancingv2.Client) ([]string, error) {
+	var arns []string
+	var marker *string
+
+	for {
+		output, err := client.DescribeTargetGroups(ctx, &elasticloadbalancingv2.DescribeTargetGroupsInput{
+			Marker: marker,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing target groups: %w", err)
+		}
+		for _, tg := range output.TargetGroups {
+			arns = append(arns, *tg.TargetGroupArn)
+		}
+		if output.NextMarker == nil {
+			return arns, nil
+		}
+		marker = output.NextMarker
+	}
+}
+
+// filterTaggedELBV2ARNs keeps the ARNs out of allARNs that carry
+// deploymentID's DeploymentId tag. ELBV2's Describe* APIs have no
+// server-side tag filter, so matching happens client-side via DescribeTags,
+// batched into groups of at most elbv2DescribeTagsBatchSize since
+// DescribeTags rejects more ResourceArns than that in one call.
+func filterTaggedELBV2ARNs(ctx context.Context, client *elasticloadbalancingv2.Client, deploymentID string, allARNs []string) ([]string, error) {
+	matched := make([]string, 0, len(allARNs))
+
+	for start := 0; start < len(allARNs); start += elbv2DescribeTagsBatchSize {
+		end := start + elbv2DescribeTagsBatchSize
+		if end > len(allARNs) {
+			end = len(allARNs)
+		}
+
+		tagsOutput, err := client.DescribeTags(ctx, &elasticloadbalancingv2.DescribeTagsInput{
+			ResourceArns: allARNs[start:end],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error describing ELBv2 tags: %w", err)
+		}
+
+		for _, tagDescription := range tagsOutput.TagDescriptions {
+			for _, tag := range tagDescription.Tags {
+				if tag.Key != nil && *tag.Key == tags.DeploymentIDKey && tag.Value != nil && *tag.Value == deploymentID {
+					matched = append(matched, *tagDescription.ResourceArn)
+					break
+				}
+			}
+		}
+	}
+
+	return matched, nil
+}
+
+func destroyInstances(ctx context.Context, logger *log.Logger, client *ec2.Client, deploymentID string) error {
+	output, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		Filters: deploymentTagFilter(deploymentID),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing instances for deployment %s: %w", deploymentID, err)
+	}
+
+	instanceIDs := make([]string, 0)
+	for _, reservation := range output.Reservations {
+		for _, instance := range reservation.Instances {
+			if instance.State.Name == types.InstanceStateNameTerminated {
+				continue
+			}
+			instanceIDs = append(instanceIDs, *instance.InstanceId)
+		}
+	}
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+
+	if _, err := client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: instanceIDs,
+	}); err != nil {
+		return fmt.Errorf("error terminating instances %v: %w", instanceIDs, err)
+	}
+	logger.Printf("Termination requested for instances %v, waiting for them to terminate...", instanceIDs)
+
+	waiter := ec2.NewInstanceTerminatedWaiter(client)
+	if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: instanceIDs}, asgDeleteTimeout); err != nil {
+		return fmt.Errorf("error waiting for instances %v to terminate: %w", instanceIDs, err)
+	}
+	logger.Printf("Instances %v terminated", instanceIDs)
+
+	return nil
+}
+
+func destroyLaunchTemplates(ctx context.Context, logger *log.Logger, client *ec2.Client, deploymentID string) error {
+	output, err := client.DescribeLaunchTemplates(ctx, &ec2.DescribeLaunchTemplatesInput{
+		Filters: deploymentTagFilter(deploymentID),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing launch templates for deployment %s: %w", deploymentID, err)
+	}
+
+	for _, launchTemplate := range output.LaunchTemplates {
+		id := *launchTemplate.LaunchTemplateId
+		if _, err := client.DeleteLaunchTemplate(ctx, &ec2.DeleteLaunchTemplateInput{
+			LaunchTemplateId: aws.String(id),
+		}); err != nil {
+			return fmt.Errorf("error deleting launch template %s: %w", id, err)
+		}
+		logger.Printf("Launch template %s deleted", id)
+	}
+
+	return nil
+}
+
+func destroySecurityGroups(ctx context.Context, logger *log.Logger, client *ec2.Client, deploymentID string) error {
+	output, err := client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: deploymentTagFilter(deploymentID),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing security groups for deployment %s: %w", deploymentID, err)
+	}
+
+	for _, securityGroup := range output.SecurityGroups {
+		id := *securityGroup.GroupId
+		err := retryOnDependencyViolation(ctx, logger, fmt.Sprintf("security group %s", id), func() error {
+			_, err := client.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{GroupId: aws.String(id)})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error deleting security group %s: %w", id, err)
+		}
+		logger.Printf("Security group %s deleted", id)
+	}
+
+	return nil
+}
+
+func destroyInternetGateways(ctx context.Context, logger *log.Logger, client *ec2.Client, deploymentID string) error {
+	output, err := client.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{
+		Filters: deploymentTagFilter(deploymentID),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing internet gateways for deployment %s: %w", deploymentID, err)
+	}
+
+	for _, igw := range output.InternetGateways {
+		id := *igw.InternetGatewayId
+
+		for _, attachment := range igw.Attachments {
+			if _, err := client.DetachInternetGateway(ctx, &ec2.DetachInternetGatewayInput{
+				InternetGatewayId: aws.String(id),
+				VpcId:             attachment.VpcId,
+			}); err != nil {
+				return fmt.Errorf("error detaching internet gateway %s from VPC %s: %w", id, *attachment.VpcId, err)
+			}
+			logger.Printf("Internet gateway %s detached from VPC %s", id, *attachment.VpcId)
+		}
+
+		if _, err := client.DeleteInternetGateway(ctx, &ec2.DeleteInternetGatewayInput{
+			InternetGatewayId: aws.String(id),
+		}); err != nil {
+			return fmt.Errorf("error deleting internet gateway %s: %w", id, err)
+		}
+		logger.Printf("Internet gateway %s deleted", id)
+	}
+
+	return nil
+}
+
+func destroyRouteTables(ctx context.Context, logger *log.Logger, client *ec2.Client, deploymentID string) error {
+	output, err := client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: deploymentTagFilter(deploymentID),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing route tables for deployment %s: %w", deploymentID, err)
+	}
+
+	for _, routeTable := range output.RouteTables {
+		id := *routeTable.RouteTableId
+
+		for _, association := range routeTable.Associations {
+			if association.Main != nil && *association.Main {
+				continue
+			}
+			if _, err := client.DisassociateRouteTable(ctx, &ec2.DisassociateRouteTableInput{
+				AssociationId: association.RouteTableAssociationId,
+			}); err != nil {
+				return fmt.Errorf("error disassociating route table %s: %w", id, err)
+			}
+		}
+
+		if _, err := client.DeleteRouteTable(ctx, &ec2.DeleteRouteTableInput{
+			RouteTableId: aws.String(id),
+		}); err != nil {
+			return fmt.Errorf("error deleting route table %s: %w", id, err)
+		}
+		logger.Printf("Route table %s deleted", id)
+	}
+
+	return nil
+}
+
+func destroySubnets(ctx context.Context, logger *log.Logger, client *ec2.Client, deploymentID string) error {
+	output, err := client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		Filters: deploymentTagFilter(deploymentID),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing subnets for deployment %s: %w", deploymentID, err)
+	}
+
+	for _, subnet := range output.Subnets {
+		id := *subnet.SubnetId
+		err := retryOnDependencyViolation(ctx, logger, fmt.Sprintf("subnet %s", id), func() error {
+			_, err := client.DeleteSubnet(ctx, &ec2.DeleteSubnetInput{SubnetId: aws.String(id)})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error deleting subnet %s: %w", id, err)
+		}
+		logger.Printf("Subnet %s deleted", id)
+	}
+
+	return nil
+}
+
+func destroyVpcs(ctx context.Context, logger *log.Logger, client *ec2.Client, deploymentID string) error {
+	output, err := client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{
+		Filters: deploymentTagFilter(deploymentID),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing VPCs for deployment %s: %w", deploymentID, err)
+	}
+
+	for _, vpc := range output.Vpcs {
+		id := *vpc.VpcId
+		err := retryOnDependencyViolation(ctx, logger, fmt.Sprintf("VPC %s", id), func() error {
+			_, err := client.DeleteVpc(ctx, &ec2.DeleteVpcInput{VpcId: aws.String(id)})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error deleting VPC %s: %w", id, err)
+		}
+		logger.Printf("VPC %s deleted", id)
+	}
+
+	return nil
+}
+
+// retryOnDependencyViolation calls delete, a delete call that may fail
+// with DependencyViolation while a just-deleted dependency (an ENI, a
+// route) is still draining, up to dependencyViolationRetries times in all.
+func retryOnDependencyViolation(ctx context.Context, logger *log.Logger, description string, delete func() error) error {
+	var lastErr error
+
+	for attempt := 1; attempt <= dependencyViolationRetries; attempt++ {
+		lastErr = delete()
+		if lastErr == nil {
+			return nil
+		}
+		if !isDependencyViolation(lastErr) {
+			return lastErr
+		}
+
+		logger.Printf("%s has a dangling dependency, retrying deletion (%d/%d)...", description, attempt, dependencyViolationRetries)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(dependencyViolationInterval):
+		}
+	}
+
+	return fmt.Errorf("gave up deleting %s after %d attempts: %w", description, dependencyViolationRetries, lastErr)
+}
+
+func isDependencyViolation(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "DependencyViolation"
+	}
+	return false
+}
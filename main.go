@@ -3,27 +3,47 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/ami"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/autoscale"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/network"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/shared"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/state"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/tags"
+	"github.com/korzepadawid/aws-autoscaling-pzc/internal/teardown"
 )
 
 const (
 	ENV_FILE_PATH    = ".env"
 	USER_DATA_SCRIPT = "user_data.sh"
 
-	AWS_REGION                 = "us-east-1"
-	AWS_AMI_ID                 = "ami-01816d07b1128cd2d" // Amazon Linux 2023 AMI
+	// AWS_REGION_ENV_VAR optionally overrides the region this tool
+	// provisions into; DEFAULT_AWS_REGION is used if it's unset.
+	AWS_REGION_ENV_VAR = "AWS_REGION"
+	DEFAULT_AWS_REGION = "us-east-1"
+
 	AWS_LAUNCH_TEMPLATE_PREFIX = "webservice-launch-template-"
-	AWS_DEFAULT_EC2_COUNT      = 2
+
+	// STATE_FILE_PATH_ENV_VAR optionally overrides where provisioning state
+	// is read from and written to, so concurrent deployments can use
+	// separate files.
+	STATE_FILE_PATH_ENV_VAR = "STATE_FILE_PATH"
+	DEFAULT_STATE_FILE_PATH = "state.json"
 )
 
 func main() {
@@ -36,183 +56,239 @@ func main() {
 	ctx, cancelFunc := context.WithTimeout(context.Background(), 6*time.Minute)
 	defer cancelFunc()
 
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithDefaultRegion(AWS_REGION))
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithDefaultRegion(awsRegion()))
 	if err != nil {
 		log.Fatal(err)
 	}
 	logger.Println("AWS configuration loaded successfully")
 	ec2Client := ec2.NewFromConfig(cfg)
+	autoscaleClients := &autoscale.Clients{
+		ELBV2: elasticloadbalancingv2.NewFromConfig(cfg),
+		ASG:   autoscaling.NewFromConfig(cfg),
+	}
+	amiResolver := ami.NewSSMResolver(ssm.NewFromConfig(cfg), ami.DefaultSSMParameterName)
 
-	vpcID, err := CreateVPC(ctx, logger, ec2Client)
-	if err != nil {
-		logger.Fatal(err)
+	if len(os.Args) > 1 && os.Args[1] == "destroy" {
+		runDestroy(ctx, logger, ec2Client, autoscaleClients, os.Args[2:])
+		return
 	}
 
-	subnetID, err := CreateSubnet(ctx, logger, ec2Client, vpcID)
+	st, err := loadOrStartState()
 	if err != nil {
 		logger.Fatal(err)
 	}
+	tagSpec := tags.NewSpec(st.DeploymentID)
+	logger.Printf("Deployment ID: %s", st.DeploymentID)
+	sharedConfig := shared.Load()
 
-	securityGroupID, err := CreateSecurityGroup(ctx, logger, ec2Client, vpcID)
+	net, err := network.CreateNetwork(ctx, logger, ec2Client, tagSpec, st, sharedConfig)
 	if err != nil {
 		logger.Fatal(err)
 	}
 
-	launchTemplateID, err := CreateLaunchTemplate(ctx, logger, ec2Client, securityGroupID)
+	securityGroupID, err := CreateSecurityGroup(ctx, logger, ec2Client, net.VpcID, tagSpec, st, sharedConfig)
 	if err != nil {
 		logger.Fatal(err)
 	}
 
-	_, err = CreateEC2Instances(ctx, logger, ec2Client, launchTemplateID, subnetID)
+	launchTemplateID, err := CreateLaunchTemplate(ctx, logger, ec2Client, amiResolver, securityGroupID, tagSpec, st)
 	if err != nil {
 		logger.Fatal(err)
 	}
-}
 
-func CreateVPC(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client) (string, error) {
-	result, err := ec2Client.CreateVpc(ctx, &ec2.CreateVpcInput{
-		CidrBlock: aws.String("10.0.0.0/16"),
-	})
+	result, err := autoscale.CreateAutoScalingGroup(ctx, logger, autoscaleClients, tagSpec, st, net.VpcID, net.PublicSubnetIDs, securityGroupID, launchTemplateID)
 	if err != nil {
-		return "", fmt.Errorf("error creating VPC: %w", err)
+		logger.Fatal(err)
 	}
-	logger.Printf("VPC created with ID: %s", *result.Vpc.VpcId)
+	logger.Printf("Web tier ready behind load balancer: %s", result.LoadBalancerDNS)
+}
 
-	modifyVPC := &ec2.ModifyVpcAttributeInput{
-		VpcId: result.Vpc.VpcId,
-		EnableDnsHostnames: &types.AttributeBooleanValue{
-			Value: aws.Bool(true),
-		},
+// awsRegion returns the region to provision into: AWS_REGION_ENV_VAR if
+// set, otherwise DEFAULT_AWS_REGION.
+func awsRegion() string {
+	if region := os.Getenv(AWS_REGION_ENV_VAR); region != "" {
+		return region
 	}
-	if _, err = ec2Client.ModifyVpcAttribute(ctx, modifyVPC); err != nil {
-		return "", fmt.Errorf("error enabling DNS hostnames: %w", err)
-	}
-	logger.Printf("DNS hostnames enabled for VPC with ID: %s", *result.Vpc.VpcId)
 
-	return *result.Vpc.VpcId, nil
+	return DEFAULT_AWS_REGION
 }
 
-func CreateSubnet(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string) (string, error) {
-	subnetResult, err := ec2Client.CreateSubnet(ctx, &ec2.CreateSubnetInput{
-		VpcId:            aws.String(vpcID),
-		CidrBlock:        aws.String("10.0.1.0/24"),
-		AvailabilityZone: aws.String(AWS_REGION),
-	})
-	if err != nil {
-		return "", fmt.Errorf("error creating subnet: %w", err)
+// loadOrStartState resumes the deployment recorded in the state file at
+// STATE_FILE_PATH_ENV_VAR (or DEFAULT_STATE_FILE_PATH), or starts a new one
+// with a fresh deployment ID if no state file exists yet.
+func loadOrStartState() (*state.State, error) {
+	path := os.Getenv(STATE_FILE_PATH_ENV_VAR)
+	if path == "" {
+		path = DEFAULT_STATE_FILE_PATH
 	}
-	logger.Printf("Subnet created with ID: %s", *subnetResult.Subnet.SubnetId)
 
-	return *subnetResult.Subnet.SubnetId, nil
+	st, err := state.Load(path)
+	if err == nil {
+		return st, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error loading state file %s: %w", path, err)
+	}
+
+	return state.New(path, uuid.NewString()), nil
 }
 
-func CreateSecurityGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string) (string, error) {
-	sgName := "webservice-sg-" + uuid.NewString()
-	sgDescription := "Security group for port 8080 access"
+func CreateSecurityGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string, tagSpec *tags.Spec, st *state.State, sharedConfig *shared.Config) (string, error) {
+	if sharedConfig.SecurityGroup() {
+		return useExistingSecurityGroup(ctx, logger, ec2Client, vpcID, sharedConfig.SecurityGroupID)
+	}
 
-	createOutput, err := ec2Client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
-		GroupName:   aws.String(sgName),
-		Description: aws.String(sgDescription),
-		VpcId:       aws.String(vpcID),
-	})
-	if err != nil {
-		return "", fmt.Errorf("error creating security group: %w", err)
-	}
-	logger.Printf("Created security group with ID: %s", *createOutput.GroupId)
-
-	ec2IngressInput := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: createOutput.GroupId,
-		IpPermissions: []types.IpPermission{
-			{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int32(8080),
-				ToPort:     aws.Int32(8080),
-				IpRanges: []types.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
+	return state.Ensure(ctx, logger, st, "security_group", securityGroupExists(ec2Client), func(ctx context.Context, record func(id string) error) (string, error) {
+		sgName := "webservice-sg-" + uuid.NewString()
+		sgDescription := "Security group for port 8080 access"
+
+		createOutput, err := ec2Client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+			GroupName:         aws.String(sgName),
+			Description:       aws.String(sgDescription),
+			VpcId:             aws.String(vpcID),
+			TagSpecifications: tagSpec.EC2(types.ResourceTypeSecurityGroup, sgName),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating security group: %w", err)
+		}
+		logger.Printf("Created security group with ID: %s", *createOutput.GroupId)
+		if err := record(*createOutput.GroupId); err != nil {
+			return "", err
+		}
+
+		ec2IngressInput := &ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId: createOutput.GroupId,
+			IpPermissions: []types.IpPermission{
+				{
+					IpProtocol: aws.String("tcp"),
+					FromPort:   aws.Int32(8080),
+					ToPort:     aws.Int32(8080),
+					IpRanges: []types.IpRange{
+						{
+							CidrIp: aws.String("0.0.0.0/0"),
+						},
 					},
 				},
 			},
-		},
-	}
-	if _, err = ec2Client.AuthorizeSecurityGroupIngress(ctx, ec2IngressInput); err != nil {
-		return "", fmt.Errorf("error adding inbound (ingress) rule for port 8080: %w", err)
-	}
-	logger.Printf("Added inbound (ingress) rule for port 8080 to security group with ID: %s", *createOutput.GroupId)
+		}
+		if _, err = ec2Client.AuthorizeSecurityGroupIngress(ctx, ec2IngressInput); err != nil {
+			return "", fmt.Errorf("error adding inbound (ingress) rule for port 8080: %w", err)
+		}
+		logger.Printf("Added inbound (ingress) rule for port 8080 to security group with ID: %s", *createOutput.GroupId)
 
-	return *createOutput.GroupId, nil
+		return *createOutput.GroupId, nil
+	})
 }
 
-func CreateLaunchTemplate(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, securityGroupID string) (string, error) {
-	userDataBytes, err := os.ReadFile(USER_DATA_SCRIPT)
-	if err != nil {
-		return "", fmt.Errorf("error reading user_data.sh file: %w", err)
-	}
-	logger.Println("user_data.sh file read successfully")
-
-	base64UserData := base64.StdEncoding.EncodeToString(userDataBytes)
-	ec2LaunchTemplate, err := ec2Client.CreateLaunchTemplate(ctx, &ec2.CreateLaunchTemplateInput{
-		LaunchTemplateData: &types.RequestLaunchTemplateData{
-			UserData:     aws.String(base64UserData),
-			ImageId:      aws.String(AWS_AMI_ID),
-			InstanceType: types.InstanceTypeT2Micro,
-			SecurityGroupIds: []string{
-				securityGroupID,
-			},
-		},
-		LaunchTemplateName: aws.String(AWS_LAUNCH_TEMPLATE_PREFIX + uuid.NewString()),
-	})
-	if err != nil {
-		return "", fmt.Errorf("error creating launch template: %w", err)
+func securityGroupExists(ec2Client *ec2.Client) func(ctx context.Context, id string) (bool, error) {
+	return func(ctx context.Context, id string) (bool, error) {
+		_, err := ec2Client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: []string{id}})
+		return state.DescribeExists(err, "InvalidGroup.NotFound")
 	}
-	logger.Printf("Launch template created with ID: %s", *ec2LaunchTemplate.LaunchTemplate.LaunchTemplateId)
-
-	return *ec2LaunchTemplate.LaunchTemplate.LaunchTemplateId, nil
 }
 
-func CreateEC2Instances(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, launchTemplateID string, subnetID string) ([]types.Instance, error) {
-	input := &ec2.RunInstancesInput{
-		LaunchTemplate: &types.LaunchTemplateSpecification{
-			LaunchTemplateId: aws.String(launchTemplateID),
-			Version:          aws.String("$Latest"),
-		},
-		MinCount: aws.Int32(AWS_DEFAULT_EC2_COUNT),
-		MaxCount: aws.Int32(AWS_DEFAULT_EC2_COUNT),
-		SubnetId: aws.String(subnetID),
-	}
-	result, err := ec2Client.RunInstances(ctx, input)
+// useExistingSecurityGroup validates that sgID exists, belongs to vpcID,
+// and already allows inbound traffic on port 8080, then returns it
+// unchanged — it is never created, tagged, or modified.
+func useExistingSecurityGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string, sgID string) (string, error) {
+	output, err := ec2Client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: []string{sgID}})
 	if err != nil {
-		log.Fatalf("Unable to launch instance, %v", err)
+		return "", fmt.Errorf("error describing existing security group %s: %w", sgID, err)
 	}
-
-	for _, instance := range result.Instances {
-		logger.Printf("Launched instance with ID: %s, IP address: %s, DNS name: %s", *instance.InstanceId, *instance.PublicIpAddress, *instance.PublicDnsName)
+	securityGroup := output.SecurityGroups[0]
+	if securityGroup.VpcId == nil || *securityGroup.VpcId != vpcID {
+		return "", fmt.Errorf("existing security group %s does not belong to VPC %s", sgID, vpcID)
+	}
+	if !allowsIngressOnPort(securityGroup.IpPermissions, 8080) {
+		return "", fmt.Errorf("existing security group %s must already allow inbound TCP on port 8080", sgID)
 	}
 
-	err = WaitForInstances(ctx, ec2Client, logger, result.Instances)
-	if err != nil {
-		return nil, fmt.Errorf("error waiting for instances to be running: %w", err)
+	logger.Printf("Reusing existing security group %s", sgID)
+
+	return sgID, nil
+}
+
+func allowsIngressOnPort(permissions []types.IpPermission, port int32) bool {
+	for _, permission := range permissions {
+		if permission.IpProtocol == nil {
+			continue
+		}
+		if *permission.IpProtocol == "-1" {
+			return true
+		}
+		if *permission.IpProtocol != "tcp" && *permission.IpProtocol != "6" {
+			continue
+		}
+		if permission.FromPort != nil && permission.ToPort != nil &&
+			*permission.FromPort <= port && port <= *permission.ToPort {
+			return true
+		}
 	}
-	logger.Println("All instances are running")
+	return false
+}
 
-	return result.Instances, nil
+func CreateLaunchTemplate(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, amiResolver ami.Resolver, securityGroupID string, tagSpec *tags.Spec, st *state.State) (string, error) {
+	return state.Ensure(ctx, logger, st, "launch_template", launchTemplateExists(ec2Client), func(ctx context.Context, record func(id string) error) (string, error) {
+		userDataBytes, err := os.ReadFile(USER_DATA_SCRIPT)
+		if err != nil {
+			return "", fmt.Errorf("error reading user_data.sh file: %w", err)
+		}
+		logger.Println("user_data.sh file read successfully")
+
+		amiID, err := amiResolver.Resolve(ctx)
+		if err != nil {
+			return "", fmt.Errorf("error resolving AMI ID: %w", err)
+		}
+		logger.Printf("Resolved AMI ID: %s", amiID)
+
+		launchTemplateName := AWS_LAUNCH_TEMPLATE_PREFIX + uuid.NewString()
+		base64UserData := base64.StdEncoding.EncodeToString(userDataBytes)
+		ec2LaunchTemplate, err := ec2Client.CreateLaunchTemplate(ctx, &ec2.CreateLaunchTemplateInput{
+			LaunchTemplateData: &types.RequestLaunchTemplateData{
+				UserData:     aws.String(base64UserData),
+				ImageId:      aws.String(amiID),
+				InstanceType: types.InstanceTypeT2Micro,
+				SecurityGroupIds: []string{
+					securityGroupID,
+				},
+			},
+			LaunchTemplateName: aws.String(launchTemplateName),
+			TagSpecifications:  tagSpec.EC2(types.ResourceTypeLaunchTemplate, launchTemplateName),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating launch template: %w", err)
+		}
+		logger.Printf("Launch template created with ID: %s", *ec2LaunchTemplate.LaunchTemplate.LaunchTemplateId)
+
+		return *ec2LaunchTemplate.LaunchTemplate.LaunchTemplateId, nil
+	})
 }
 
-func WaitForInstances(ctx context.Context, client *ec2.Client, logger *log.Logger, instances []types.Instance) error {
-	instanceIDs := make([]string, len(instances))
-	for i, instance := range instances {
-		instanceIDs[i] = *instance.InstanceId
+func launchTemplateExists(ec2Client *ec2.Client) func(ctx context.Context, id string) (bool, error) {
+	return func(ctx context.Context, id string) (bool, error) {
+		_, err := ec2Client.DescribeLaunchTemplates(ctx, &ec2.DescribeLaunchTemplatesInput{LaunchTemplateIds: []string{id}})
+		return state.DescribeExists(err, "InvalidLaunchTemplateId.NotFound")
 	}
+}
 
-	input := &ec2.DescribeInstancesInput{
-		InstanceIds: instanceIDs,
+// runDestroy handles `destroy <deployment-id>`: it tears down every
+// resource tagged with that deployment ID, reversing what main's
+// provisioning flow created.
+func runDestroy(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, autoscaleClients *autoscale.Clients, args []string) {
+	if len(args) != 1 {
+		logger.Fatal("usage: destroy <deployment-id>")
 	}
+	deploymentID := args[0]
 
-	waiter := ec2.NewInstanceRunningWaiter(client, func(irwo *ec2.InstanceRunningWaiterOptions) {
-		irwo.LogWaitAttempts = true
-	})
+	teardownClients := &teardown.Clients{
+		EC2:   ec2Client,
+		ELBV2: autoscaleClients.ELBV2,
+		ASG:   autoscaleClients.ASG,
+	}
 
-	logger.Println("Waiting for instances to be running...")
-	logger.Println("This may take a few minutes...")
-	return waiter.Wait(ctx, input, 5*time.Minute)
+	logger.Printf("Destroying deployment %s", deploymentID)
+	if err := teardown.Destroy(ctx, logger, teardownClients, deploymentID); err != nil {
+		logger.Fatal(err)
+	}
+	logger.Printf("Deployment %s destroyed", deploymentID)
 }